@@ -0,0 +1,175 @@
+// Package certs provides a minimal self-signed CA and leaf-certificate
+// generator so the webhook server can bootstrap its own TLS material
+// instead of depending on a pre-provisioned cert/key pair.
+package certs
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	rsaKeyBits = 2048
+	caValidity = 10 * 365 * 24 * time.Hour
+	validity   = 90 * 24 * time.Hour
+
+	// DefaultRotationInterval is a sensible default for how often a caller
+	// should invoke Rotate, comfortably inside the leaf certificate's
+	// validity period.
+	DefaultRotationInterval = validity / 2
+)
+
+// SelfSigner holds a self-signed CA keypair and the leaf keypair it has
+// issued for the webhook server. It is safe for concurrent use.
+type SelfSigner struct {
+	mu sync.RWMutex
+
+	caCert *x509.Certificate
+	caKey  *rsa.PrivateKey
+
+	leafCertPEM []byte
+	leafKeyPEM  []byte
+	leafCert    tls.Certificate
+
+	sans []string
+}
+
+// NewSelfSigner generates a fresh CA and a leaf certificate for the given
+// SANs (typically the webhook Service DNS names, e.g. "<svc>.<ns>.svc").
+func NewSelfSigner(sans []string) (*SelfSigner, error) {
+	s := &SelfSigner{sans: sans}
+	if err := s.generateCA(); err != nil {
+		return nil, fmt.Errorf("generating CA: %v", err)
+	}
+	if err := s.Rotate(); err != nil {
+		return nil, fmt.Errorf("issuing leaf certificate: %v", err)
+	}
+	return s, nil
+}
+
+func (s *SelfSigner) generateCA() error {
+	key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		return err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "webhook-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.caCert = cert
+	s.caKey = key
+	return nil
+}
+
+// Rotate issues a new leaf certificate signed by the current CA, replacing
+// whatever leaf certificate was previously in use.
+func (s *SelfSigner) Rotate() error {
+	s.mu.RLock()
+	caCert, caKey, sans := s.caCert, s.caKey, s.sans
+	s.mu.RUnlock()
+
+	key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		return err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: sansCommonName(sans)},
+		DNSNames:     sans,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	leaf, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leafCertPEM = certPEM
+	s.leafKeyPEM = keyPEM
+	s.leafCert = leaf
+	return nil
+}
+
+// CABundle returns the PEM-encoded CA certificate, suitable for the
+// MutatingWebhookConfiguration's clientConfig.caBundle field.
+func (s *SelfSigner) CABundle() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw})
+}
+
+// LeafCertificate returns the current tls.Certificate served by the webhook.
+func (s *SelfSigner) LeafCertificate() tls.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.leafCert
+}
+
+// LeafPEM returns the PEM-encoded leaf certificate and key.
+func (s *SelfSigner) LeafPEM() (certPEM, keyPEM []byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.leafCertPEM, s.leafKeyPEM
+}
+
+func newSerial() (*big.Int, error) {
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	return rand.Int(rand.Reader, limit)
+}
+
+func sansCommonName(sans []string) string {
+	if len(sans) == 0 {
+		return "webhook-server"
+	}
+	return sans[0]
+}
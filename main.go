@@ -8,17 +8,34 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/falfaro/kube-mutating-webhook-tutorial/certs"
+	"github.com/falfaro/kube-mutating-webhook-tutorial/policy"
+	"github.com/falfaro/kube-mutating-webhook-tutorial/registrar"
 )
 
 // Webhook Server parameters
 type whSvrParameters struct {
-	port      int    // webhook server port
-	certFile  string // path to the x509 certificate for https
-	keyFile   string // path to the x509 private key matching `CertFile`
-	dnsSuffix string // DNS domain suffix
+	port       int    // webhook server port
+	certFile   string // path to the x509 certificate for https
+	keyFile    string // path to the x509 private key matching `CertFile`
+	dnsSuffix  string // DNS domain suffix
+	configFile string // path to the sidecar/ingress-policy config file
+	mode       string // comma-separated list of endpoints to serve: mutate, validate
+
+	selfSign           bool   // generate and manage our own CA/server certificate
+	webhookService     string // name of the Service fronting this webhook
+	webhookNamespace   string // namespace the webhook Service lives in
+	mutatingConfigName string // name of the MutatingWebhookConfiguration to keep in sync
 }
 
 func main() {
@@ -29,28 +46,56 @@ func main() {
 	flag.StringVar(&parameters.certFile, "tlsCertFile", "/etc/webhook/certs/cert.pem", "File containing the x509 Certificate for HTTPS.")
 	flag.StringVar(&parameters.keyFile, "tlsKeyFile", "/etc/webhook/certs/key.pem", "File containing the x509 private key to --tlsCertFile.")
 	flag.StringVar(&parameters.dnsSuffix, "dnsSuffix", "", "DNS domain suffix for unqualified Ingress resources.")
+	flag.StringVar(&parameters.configFile, "sidecarCfgFile", "", "File containing the sidecar injector and Ingress mutation policy configuration. Reloaded on SIGHUP.")
+	flag.StringVar(&parameters.mode, "mode", "mutate,validate", "Comma-separated list of endpoints to serve: mutate, validate.")
+	flag.BoolVar(&parameters.selfSign, "self-sign", false, "Generate a self-signed CA/server certificate and keep the MutatingWebhookConfiguration's caBundle in sync with it, instead of loading --tlsCertFile/--tlsKeyFile from disk.")
+	flag.StringVar(&parameters.webhookService, "webhook-service", "", "Name of the Service fronting this webhook. Required with --self-sign.")
+	flag.StringVar(&parameters.webhookNamespace, "webhook-namespace", "", "Namespace the webhook Service lives in. Required with --self-sign.")
+	flag.StringVar(&parameters.mutatingConfigName, "mutating-config-name", "", "Name of the MutatingWebhookConfiguration to create or patch. Required with --self-sign.")
 	flag.Parse()
 
 	if parameters.dnsSuffix == "" {
 		glog.Fatalln("No DNS suffix was specified")
 	}
 
-	pair, err := tls.LoadX509KeyPair(parameters.certFile, parameters.keyFile)
-	if err != nil {
-		glog.Errorf("Filed to load key pair: %v", err)
-	}
-
-	whsvr := &webhookServer{
+	whsvr := &WebhookServer{
 		dnsSuffix: parameters.dnsSuffix,
 		server: &http.Server{
-			Addr:      fmt.Sprintf(":%v", parameters.port),
-			TLSConfig: &tls.Config{Certificates: []tls.Certificate{pair}},
+			Addr: fmt.Sprintf(":%v", parameters.port),
 		},
 	}
 
+	if err := reloadPolicy(whsvr, parameters); err != nil {
+		glog.Fatalf("Failed to load %s: %v", parameters.configFile, err)
+	}
+	watchConfigReloads(whsvr, parameters)
+
+	if parameters.selfSign {
+		if parameters.webhookService == "" || parameters.webhookNamespace == "" || parameters.mutatingConfigName == "" {
+			glog.Fatalln("--webhook-service, --webhook-namespace and --mutating-config-name are required with --self-sign")
+		}
+		if err := bootstrapSelfSignedCerts(whsvr, parameters); err != nil {
+			glog.Fatalf("Failed to bootstrap self-signed certificates: %v", err)
+		}
+	} else {
+		pair, err := tls.LoadX509KeyPair(parameters.certFile, parameters.keyFile)
+		if err != nil {
+			glog.Errorf("Filed to load key pair: %v", err)
+		}
+		whsvr.setCertificate(pair)
+	}
+	whsvr.server.TLSConfig = &tls.Config{GetCertificate: whsvr.getCertificate}
+
 	// define http server and server handler
+	modes := parseModes(parameters.mode)
 	mux := http.NewServeMux()
-	mux.HandleFunc("/mutate", whsvr.serve)
+	if modes["mutate"] {
+		mux.HandleFunc("/mutate", whsvr.serveMutate)
+	}
+	if modes["validate"] {
+		mux.HandleFunc("/validate", whsvr.serveValidate)
+	}
+	mux.Handle("/metrics", promhttp.Handler())
 	whsvr.server.Handler = mux
 
 	// start webhook server in new rountine
@@ -68,3 +113,123 @@ func main() {
 	glog.Infof("Got OS shutdown signal, shutting down wenhook server gracefully...")
 	whsvr.server.Shutdown(context.Background())
 }
+
+// bootstrapSelfSignedCerts generates a self-signed CA and server
+// certificate for the webhook Service, installs the server certificate on
+// whsvr, and creates or patches the MutatingWebhookConfiguration so its
+// caBundle matches the CA that signed it.
+func bootstrapSelfSignedCerts(whsvr *WebhookServer, parameters whSvrParameters) error {
+	sans := []string{
+		parameters.webhookService,
+		fmt.Sprintf("%s.%s", parameters.webhookService, parameters.webhookNamespace),
+		fmt.Sprintf("%s.%s.svc", parameters.webhookService, parameters.webhookNamespace),
+	}
+
+	signer, err := certs.NewSelfSigner(sans)
+	if err != nil {
+		return fmt.Errorf("generating self-signed certificate: %v", err)
+	}
+	whsvr.setCertificate(signer.LeafCertificate())
+
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return fmt.Errorf("loading in-cluster config: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("building Kubernetes client: %v", err)
+	}
+
+	reg := registrar.New(client, registrar.WebhookTarget{
+		ConfigName: parameters.mutatingConfigName,
+		Service:    parameters.webhookService,
+		Namespace:  parameters.webhookNamespace,
+		Path:       "/mutate",
+		NamespaceSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{namespaceSelectorLabel: "enabled"},
+		},
+	})
+	if err := reg.SyncCABundle(context.Background(), signer.CABundle()); err != nil {
+		return fmt.Errorf("syncing caBundle: %v", err)
+	}
+
+	go watchCertRotation(whsvr, signer, reg)
+
+	return nil
+}
+
+// watchCertRotation rotates signer's leaf certificate every
+// certs.DefaultRotationInterval for the life of the process, reinstalling
+// it on whsvr so new TLS connections pick it up and re-syncing reg's
+// caBundle in case the MutatingWebhookConfiguration was ever reset.
+func watchCertRotation(whsvr *WebhookServer, signer *certs.SelfSigner, reg *registrar.Registrar) {
+	ticker := time.NewTicker(certs.DefaultRotationInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		glog.Infof("Rotating self-signed leaf certificate")
+		if err := signer.Rotate(); err != nil {
+			glog.Errorf("Failed to rotate leaf certificate: %v", err)
+			continue
+		}
+		whsvr.setCertificate(signer.LeafCertificate())
+		if err := reg.SyncCABundle(context.Background(), signer.CABundle()); err != nil {
+			glog.Errorf("Failed to sync caBundle after rotation: %v", err)
+		}
+	}
+}
+
+// parseModes splits a comma-separated --mode flag value into the set of
+// endpoints it names.
+func parseModes(mode string) map[string]bool {
+	modes := map[string]bool{}
+	for _, m := range strings.Split(mode, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			modes[m] = true
+		}
+	}
+	return modes
+}
+
+// reloadPolicy (re)loads parameters.configFile, if set, and installs the
+// sidecar config and Ingress mutation policy.Engine it describes onto
+// whsvr. With no configFile, whsvr falls back to a policy.Engine built
+// solely from --dnsSuffix.
+func reloadPolicy(whsvr *WebhookServer, parameters whSvrParameters) error {
+	var cfg Config
+	if parameters.configFile != "" {
+		loaded, err := loadConfig(parameters.configFile)
+		if err != nil {
+			return err
+		}
+		cfg = *loaded
+	}
+
+	engine, err := policy.NewEngine(cfg.IngressPolicy, parameters.dnsSuffix)
+	if err != nil {
+		return fmt.Errorf("building ingress policy engine: %v", err)
+	}
+
+	whsvr.setSidecarConfig(&cfg)
+	whsvr.setPolicyEngine(engine)
+	return nil
+}
+
+// watchConfigReloads reloads parameters.configFile whenever the process
+// receives SIGHUP, so operators can change routing behavior without
+// restarting the pod.
+func watchConfigReloads(whsvr *WebhookServer, parameters whSvrParameters) {
+	if parameters.configFile == "" {
+		return
+	}
+
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			glog.Infof("Got SIGHUP, reloading %s", parameters.configFile)
+			if err := reloadPolicy(whsvr, parameters); err != nil {
+				glog.Errorf("Failed to reload %s: %v", parameters.configFile, err)
+			}
+		}
+	}()
+}
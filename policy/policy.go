@@ -0,0 +1,252 @@
+// Package policy turns a declarative Ingress mutation policy into the
+// JSON-Patch operations needed to fix up TLS hosts, so operators can change
+// routing behavior by editing a config file instead of rebuilding the
+// webhook.
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	govalidator "gopkg.in/go-playground/validator.v9"
+)
+
+// HostRewrite rewrites any TLS host matching Match (a regular expression)
+// to Replace, following regexp.ReplaceAllString's submatch syntax.
+type HostRewrite struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"`
+}
+
+// Config is the declarative Ingress mutation policy, loaded from the
+// ingressPolicy block of the sidecar injector config file.
+type Config struct {
+	// DefaultSuffix is appended to any non-FQDN TLS host that has no
+	// namespace-specific override in NamespaceSuffix.
+	DefaultSuffix string `yaml:"defaultSuffix"`
+	// NamespaceSuffix overrides DefaultSuffix for the given namespaces.
+	NamespaceSuffix map[string]string `yaml:"namespaceSuffix"`
+	// HostRewrites are applied, in order, to every non-empty host before
+	// the FQDN check. The first matching rewrite wins.
+	HostRewrites []HostRewrite `yaml:"hostRewrites"`
+	// FallbackHostTemplate is a text/template executed with an
+	// Ingress/Suffix data value to produce the host used when a TLS entry
+	// has no hosts, or an empty host, at all. Defaults to
+	// "{{.Ingress.Name}}.{{.Ingress.Namespace}}.{{.Suffix}}".
+	FallbackHostTemplate string `yaml:"fallbackHostTemplate"`
+	// AllowedWildcardHosts lists the wildcard hosts (e.g. "*.example.com")
+	// Validate permits. Any other wildcard host is rejected.
+	AllowedWildcardHosts []string `yaml:"allowedWildcardHosts"`
+}
+
+const defaultFallbackHostTemplate = "{{.Ingress.Name}}.{{.Ingress.Namespace}}.{{.Suffix}}"
+
+// Ingress is the subset of Ingress metadata the policy Engine needs.
+type Ingress struct {
+	Name      string
+	Namespace string
+}
+
+// TLSEntry is a single spec.tls[] entry.
+type TLSEntry struct {
+	SecretName string
+	Hosts      []string
+}
+
+// PatchOp is a JSON-Patch "replace" or "add" operation, independent of any
+// particular admission API version.
+type PatchOp struct {
+	Op    string
+	Path  string
+	Value interface{}
+}
+
+type compiledRewrite struct {
+	match   *regexp.Regexp
+	replace string
+}
+
+// Violation describes a single declared invariant an Ingress failed to
+// satisfy, for use by a ValidatingWebhookConfiguration.
+type Violation struct {
+	Path    string
+	Message string
+}
+
+// Engine evaluates a Config against Ingress TLS entries.
+type Engine struct {
+	cfg             Config
+	rewrites        []compiledRewrite
+	fallback        *template.Template
+	allowedWildcard map[string]bool
+}
+
+// NewEngine compiles the regexes and fallback template in cfg. dnsSuffix is
+// used as the DefaultSuffix when cfg does not specify one, so operators can
+// keep relying on the --dnsSuffix flag until they opt into a config file.
+func NewEngine(cfg Config, dnsSuffix string) (*Engine, error) {
+	if cfg.DefaultSuffix == "" {
+		cfg.DefaultSuffix = dnsSuffix
+	}
+	if cfg.FallbackHostTemplate == "" {
+		cfg.FallbackHostTemplate = defaultFallbackHostTemplate
+	}
+
+	e := &Engine{cfg: cfg, allowedWildcard: map[string]bool{}}
+	for _, host := range cfg.AllowedWildcardHosts {
+		e.allowedWildcard[host] = true
+	}
+
+	for _, rw := range cfg.HostRewrites {
+		re, err := regexp.Compile(rw.Match)
+		if err != nil {
+			return nil, fmt.Errorf("compiling host rewrite %q: %v", rw.Match, err)
+		}
+		e.rewrites = append(e.rewrites, compiledRewrite{match: re, replace: rw.Replace})
+	}
+
+	tmpl, err := template.New("fallbackHost").Parse(cfg.FallbackHostTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("parsing fallbackHostTemplate: %v", err)
+	}
+	e.fallback = tmpl
+
+	return e, nil
+}
+
+// Evaluate returns the JSON-Patch operations needed to fix up every TLS
+// entry's hosts on ing.
+func (e *Engine) Evaluate(ing Ingress, tlsEntries []TLSEntry) ([]PatchOp, error) {
+	var patch []PatchOp
+
+	for tlsIndex, tls := range tlsEntries {
+		if len(tls.Hosts) == 0 {
+			host, err := e.fallbackHost(ing)
+			if err != nil {
+				return nil, err
+			}
+			patch = append(patch, PatchOp{
+				Op:    "replace",
+				Path:  fmt.Sprintf("/spec/tls/%d/hosts", tlsIndex),
+				Value: []string{host},
+			})
+			continue
+		}
+
+		for hostIndex, host := range tls.Hosts {
+			newHost, changed, err := e.resolveHost(ing, host)
+			if err != nil {
+				return nil, err
+			}
+			if !changed {
+				continue
+			}
+			patch = append(patch, PatchOp{
+				Op:    "replace",
+				Path:  fmt.Sprintf("/spec/tls/%d/hosts/%d", tlsIndex, hostIndex),
+				Value: newHost,
+			})
+		}
+	}
+
+	return patch, nil
+}
+
+// Validate reports every way ing's TLS entries violate the invariants this
+// policy declares: a TLS entry must name a secretName, must have at least
+// one host unless a suffix is configured to synthesize a fallback one, and
+// any wildcard host it lists must appear in AllowedWildcardHosts.
+func (e *Engine) Validate(ing Ingress, tlsEntries []TLSEntry) []Violation {
+	var violations []Violation
+
+	for tlsIndex, tls := range tlsEntries {
+		path := fmt.Sprintf("/spec/tls/%d", tlsIndex)
+
+		if tls.SecretName == "" {
+			violations = append(violations, Violation{
+				Path:    path + "/secretName",
+				Message: "secretName must not be empty",
+			})
+		}
+
+		if len(tls.Hosts) == 0 && e.suffixFor(ing.Namespace) == "" {
+			violations = append(violations, Violation{
+				Path:    path + "/hosts",
+				Message: "no hosts given and no suffix is configured to derive a fallback host",
+			})
+		}
+
+		for hostIndex, host := range tls.Hosts {
+			if strings.HasPrefix(host, "*.") && !e.allowedWildcard[host] {
+				violations = append(violations, Violation{
+					Path:    fmt.Sprintf("%s/hosts/%d", path, hostIndex),
+					Message: fmt.Sprintf("wildcard host %q is not in the allowed list", host),
+				})
+			}
+		}
+	}
+
+	return violations
+}
+
+// resolveHost applies the configured rewrites and suffix to host, returning
+// the resulting host and whether it differs from the original.
+func (e *Engine) resolveHost(ing Ingress, host string) (string, bool, error) {
+	if len(host) == 0 {
+		fallback, err := e.fallbackHost(ing)
+		return fallback, true, err
+	}
+
+	rewritten := e.rewriteHost(host)
+
+	// A wildcard host can never pass the fqdn check below, but it is not an
+	// unqualified host either: leave it alone rather than appending a
+	// suffix to it. Validate is what rejects one that isn't allow-listed.
+	if strings.HasPrefix(rewritten, "*.") {
+		return rewritten, rewritten != host, nil
+	}
+
+	v := govalidator.New()
+	if err := v.Var(rewritten, "fqdn"); err == nil {
+		return rewritten, rewritten != host, nil
+	}
+
+	suffixed := rewritten
+	if !strings.HasSuffix(suffixed, ".") {
+		suffixed += "."
+	}
+	suffixed += e.suffixFor(ing.Namespace)
+	return suffixed, true, nil
+}
+
+func (e *Engine) rewriteHost(host string) string {
+	for _, rw := range e.rewrites {
+		if rw.match.MatchString(host) {
+			return rw.match.ReplaceAllString(host, rw.replace)
+		}
+	}
+	return host
+}
+
+func (e *Engine) suffixFor(namespace string) string {
+	if suffix, ok := e.cfg.NamespaceSuffix[namespace]; ok {
+		return suffix
+	}
+	return e.cfg.DefaultSuffix
+}
+
+func (e *Engine) fallbackHost(ing Ingress) (string, error) {
+	data := struct {
+		Ingress Ingress
+		Suffix  string
+	}{Ingress: ing, Suffix: e.suffixFor(ing.Namespace)}
+
+	var buf bytes.Buffer
+	if err := e.fallback.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("executing fallbackHostTemplate: %v", err)
+	}
+	return buf.String(), nil
+}
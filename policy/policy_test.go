@@ -0,0 +1,154 @@
+package policy
+
+import "testing"
+
+func TestEvaluateEmptyHosts(t *testing.T) {
+	e, err := NewEngine(Config{DefaultSuffix: "example.com"}, "")
+	if err != nil {
+		t.Fatalf("NewEngine returned an error: %v", err)
+	}
+
+	ing := Ingress{Name: "cafe", Namespace: "default"}
+	patch, err := e.Evaluate(ing, []TLSEntry{{SecretName: "cafe-tls"}})
+	if err != nil {
+		t.Fatalf("Evaluate returned an error: %v", err)
+	}
+
+	if len(patch) != 1 {
+		t.Fatalf("expected 1 patch op, got %d: %+v", len(patch), patch)
+	}
+	if patch[0].Op != "replace" || patch[0].Path != "/spec/tls/0/hosts" {
+		t.Errorf("unexpected patch op: %+v", patch[0])
+	}
+	hosts, ok := patch[0].Value.([]string)
+	if !ok || len(hosts) != 1 || hosts[0] != "cafe.default.example.com" {
+		t.Errorf("unexpected fallback host: %#v", patch[0].Value)
+	}
+}
+
+func TestEvaluateRewriteThenStillNonFQDN(t *testing.T) {
+	e, err := NewEngine(Config{
+		DefaultSuffix: "example.com",
+		HostRewrites:  []HostRewrite{{Match: `^cafe$`, Replace: "coffee"}},
+	}, "")
+	if err != nil {
+		t.Fatalf("NewEngine returned an error: %v", err)
+	}
+
+	ing := Ingress{Name: "cafe", Namespace: "default"}
+	patch, err := e.Evaluate(ing, []TLSEntry{{SecretName: "cafe-tls", Hosts: []string{"cafe"}}})
+	if err != nil {
+		t.Fatalf("Evaluate returned an error: %v", err)
+	}
+
+	if len(patch) != 1 {
+		t.Fatalf("expected 1 patch op, got %d: %+v", len(patch), patch)
+	}
+	if patch[0].Path != "/spec/tls/0/hosts/0" {
+		t.Errorf("unexpected patch path: %q", patch[0].Path)
+	}
+	if patch[0].Value != "coffee.example.com" {
+		t.Errorf("expected the rewritten host to still get suffixed, got %#v", patch[0].Value)
+	}
+}
+
+func TestEvaluateAllowedWildcardHostIsUnchanged(t *testing.T) {
+	e, err := NewEngine(Config{
+		DefaultSuffix:        "example.com",
+		AllowedWildcardHosts: []string{"*.example.com"},
+	}, "")
+	if err != nil {
+		t.Fatalf("NewEngine returned an error: %v", err)
+	}
+
+	ing := Ingress{Name: "cafe", Namespace: "default"}
+	patch, err := e.Evaluate(ing, []TLSEntry{{SecretName: "cafe-tls", Hosts: []string{"*.example.com"}}})
+	if err != nil {
+		t.Fatalf("Evaluate returned an error: %v", err)
+	}
+	if len(patch) != 0 {
+		t.Errorf("expected a wildcard host to pass through Evaluate unchanged, got %+v", patch)
+	}
+}
+
+func TestEvaluateAlreadyFQDNIsUnchanged(t *testing.T) {
+	e, err := NewEngine(Config{DefaultSuffix: "example.com"}, "")
+	if err != nil {
+		t.Fatalf("NewEngine returned an error: %v", err)
+	}
+
+	ing := Ingress{Name: "cafe", Namespace: "default"}
+	patch, err := e.Evaluate(ing, []TLSEntry{{SecretName: "cafe-tls", Hosts: []string{"cafe.example.com"}}})
+	if err != nil {
+		t.Fatalf("Evaluate returned an error: %v", err)
+	}
+	if len(patch) != 0 {
+		t.Errorf("expected no patch ops for an already-FQDN host, got %+v", patch)
+	}
+}
+
+func TestValidateWildcardAllowList(t *testing.T) {
+	cases := []struct {
+		name           string
+		allowed        []string
+		host           string
+		wantViolations int
+	}{
+		{name: "allowed wildcard passes", allowed: []string{"*.example.com"}, host: "*.example.com", wantViolations: 0},
+		{name: "unlisted wildcard is rejected", allowed: nil, host: "*.example.com", wantViolations: 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			e, err := NewEngine(Config{DefaultSuffix: "example.com", AllowedWildcardHosts: c.allowed}, "")
+			if err != nil {
+				t.Fatalf("NewEngine returned an error: %v", err)
+			}
+
+			ing := Ingress{Name: "cafe", Namespace: "default"}
+			violations := e.Validate(ing, []TLSEntry{{SecretName: "cafe-tls", Hosts: []string{c.host}}})
+			if len(violations) != c.wantViolations {
+				t.Errorf("Validate() = %+v, want %d violation(s)", violations, c.wantViolations)
+			}
+		})
+	}
+}
+
+func TestValidateMissingSecretName(t *testing.T) {
+	e, err := NewEngine(Config{DefaultSuffix: "example.com"}, "")
+	if err != nil {
+		t.Fatalf("NewEngine returned an error: %v", err)
+	}
+
+	ing := Ingress{Name: "cafe", Namespace: "default"}
+	violations := e.Validate(ing, []TLSEntry{{Hosts: []string{"cafe.example.com"}}})
+	if len(violations) != 1 || violations[0].Path != "/spec/tls/0/secretName" {
+		t.Errorf("expected a single secretName violation, got %+v", violations)
+	}
+}
+
+func TestValidateNoHostsNoSuffix(t *testing.T) {
+	e, err := NewEngine(Config{}, "")
+	if err != nil {
+		t.Fatalf("NewEngine returned an error: %v", err)
+	}
+
+	ing := Ingress{Name: "cafe", Namespace: "default"}
+	violations := e.Validate(ing, []TLSEntry{{SecretName: "cafe-tls"}})
+	if len(violations) != 1 || violations[0].Path != "/spec/tls/0/hosts" {
+		t.Errorf("expected a single hosts violation, got %+v", violations)
+	}
+}
+
+func TestValidateNoHostsWithSuffixIsFine(t *testing.T) {
+	e, err := NewEngine(Config{DefaultSuffix: "example.com"}, "")
+	if err != nil {
+		t.Fatalf("NewEngine returned an error: %v", err)
+	}
+
+	ing := Ingress{Name: "cafe", Namespace: "default"}
+	violations := e.Validate(ing, []TLSEntry{{SecretName: "cafe-tls"}})
+	if len(violations) != 0 {
+		t.Errorf("expected no violations when a suffix can derive a fallback host, got %+v", violations)
+	}
+}
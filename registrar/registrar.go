@@ -0,0 +1,138 @@
+// Package registrar keeps a MutatingWebhookConfiguration's clientConfig
+// in sync with the CA bundle the webhook server is currently serving,
+// creating the configuration if it does not yet exist.
+package registrar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/golang/glog"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// WebhookTarget describes the Service that fronts the webhook server, the
+// path it serves the mutating admission endpoint on, and which namespaces
+// the apiserver should send admission requests from.
+type WebhookTarget struct {
+	ConfigName        string
+	Service           string
+	Namespace         string
+	Path              string
+	NamespaceSelector *metav1.LabelSelector
+}
+
+// Registrar creates or patches a MutatingWebhookConfiguration so its
+// clientConfig.caBundle matches the CA currently used to sign the
+// webhook server's certificate.
+type Registrar struct {
+	client kubernetes.Interface
+	target WebhookTarget
+}
+
+// New returns a Registrar for the given target webhook configuration.
+func New(client kubernetes.Interface, target WebhookTarget) *Registrar {
+	return &Registrar{client: client, target: target}
+}
+
+// SyncCABundle creates the MutatingWebhookConfiguration if it is missing,
+// or patches every webhook entry's clientConfig.caBundle in place.
+func (r *Registrar) SyncCABundle(ctx context.Context, caBundle []byte) error {
+	client := r.client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
+
+	existing, err := client.Get(ctx, r.target.ConfigName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		glog.Infof("MutatingWebhookConfiguration %s not found, creating it", r.target.ConfigName)
+		_, err := client.Create(ctx, r.newConfiguration(caBundle), metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("getting MutatingWebhookConfiguration %s: %v", r.target.ConfigName, err)
+	}
+	if len(existing.Webhooks) == 0 {
+		glog.Infof("MutatingWebhookConfiguration %s has no webhooks, nothing to patch", r.target.ConfigName)
+		return nil
+	}
+
+	patch, err := caBundlePatch(len(existing.Webhooks), caBundle)
+	if err != nil {
+		return fmt.Errorf("building caBundle patch: %v", err)
+	}
+
+	_, err = client.Patch(ctx, r.target.ConfigName, types.JSONPatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+func (r *Registrar) newConfiguration(caBundle []byte) *admissionregistrationv1beta1.MutatingWebhookConfiguration {
+	path := r.target.Path
+	failurePolicy := admissionregistrationv1beta1.Ignore
+	return &admissionregistrationv1beta1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: r.target.ConfigName},
+		Webhooks: []admissionregistrationv1beta1.MutatingWebhook{
+			{
+				Name:              r.target.ConfigName + ".webhook.svc",
+				FailurePolicy:     &failurePolicy,
+				Rules:             mutatedResources(),
+				NamespaceSelector: r.target.NamespaceSelector,
+				ClientConfig: admissionregistrationv1beta1.WebhookClientConfig{
+					CABundle: caBundle,
+					Service: &admissionregistrationv1beta1.ServiceReference{
+						Name:      r.target.Service,
+						Namespace: r.target.Namespace,
+						Path:      &path,
+					},
+				},
+			},
+		},
+	}
+}
+
+// mutatedResources lists the GVKs the webhook server actually mutates:
+// Pods, on creation, for sidecar injection, and Ingresses of every API
+// group/version the server knows how to decode, for TLS-host rewriting.
+// Without Rules, the apiserver never invokes the webhook for any resource.
+func mutatedResources() []admissionregistrationv1beta1.RuleWithOperations {
+	return []admissionregistrationv1beta1.RuleWithOperations{
+		{
+			Operations: []admissionregistrationv1beta1.OperationType{admissionregistrationv1beta1.Create},
+			Rule: admissionregistrationv1beta1.Rule{
+				APIGroups:   []string{""},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"pods"},
+			},
+		},
+		{
+			Operations: []admissionregistrationv1beta1.OperationType{admissionregistrationv1beta1.Create, admissionregistrationv1beta1.Update},
+			Rule: admissionregistrationv1beta1.Rule{
+				APIGroups:   []string{"extensions", "networking.k8s.io"},
+				APIVersions: []string{"v1beta1", "v1"},
+				Resources:   []string{"ingresses"},
+			},
+		},
+	}
+}
+
+// caBundlePatch builds a JSON patch replacing clientConfig.caBundle on
+// every webhook entry in the configuration.
+func caBundlePatch(numWebhooks int, caBundle []byte) ([]byte, error) {
+	type jsonPatchOp struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		Value []byte `json:"value"`
+	}
+
+	var ops []jsonPatchOp
+	for i := 0; i < numWebhooks; i++ {
+		ops = append(ops, jsonPatchOp{
+			Op:    "replace",
+			Path:  fmt.Sprintf("/webhooks/%d/clientConfig/caBundle", i),
+			Value: caBundle,
+		})
+	}
+	return json.Marshal(ops)
+}
@@ -2,22 +2,31 @@ package main
 
 import (
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 
+	"github.com/falfaro/kube-mutating-webhook-tutorial/policy"
 	"github.com/ghodss/yaml"
 	"github.com/golang/glog"
-	govalidator "gopkg.in/go-playground/validator.v9"
-	"k8s.io/api/admission/v1beta1"
+	"github.com/prometheus/client_golang/prometheus"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
 	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
 	v1 "k8s.io/kubernetes/pkg/apis/core/v1"
 )
 
@@ -30,9 +39,85 @@ var (
 	defaulter = runtime.ObjectDefaulter(runtimeScheme)
 )
 
+// admissionRequestsTotal counts every admission request this server has
+// decided, by endpoint ("mutate" or "validate") and whether it was allowed.
+var admissionRequestsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "admission_requests_total",
+		Help: "Total number of admission requests handled, by endpoint and outcome.",
+	},
+	[]string{"endpoint", "allowed"},
+)
+
+func init() {
+	prometheus.MustRegister(admissionRequestsTotal)
+}
+
 type WebhookServer struct {
+	dnsSuffix string
+	server    *http.Server
+
+	certMu  sync.RWMutex
+	certPtr *tls.Certificate
+
+	policyMu     sync.RWMutex
+	policyEngine *policy.Engine
+
+	sidecarMu     sync.RWMutex
 	sidecarConfig *Config
-	server        *http.Server
+}
+
+// setSidecarConfig installs the sidecar injector configuration whsvr mutates
+// Pods with, replacing whatever configuration was previously in use. It is
+// safe to call while the server is running, e.g. on a SIGHUP-triggered
+// config reload.
+func (whsvr *WebhookServer) setSidecarConfig(cfg *Config) {
+	whsvr.sidecarMu.Lock()
+	defer whsvr.sidecarMu.Unlock()
+	whsvr.sidecarConfig = cfg
+}
+
+func (whsvr *WebhookServer) currentSidecarConfig() *Config {
+	whsvr.sidecarMu.RLock()
+	defer whsvr.sidecarMu.RUnlock()
+	return whsvr.sidecarConfig
+}
+
+// setPolicyEngine installs the Ingress mutation policy the server should
+// evaluate incoming Ingresses against, replacing whatever policy was
+// previously in use. It is safe to call while the server is running, e.g.
+// on a SIGHUP-triggered config reload.
+func (whsvr *WebhookServer) setPolicyEngine(engine *policy.Engine) {
+	whsvr.policyMu.Lock()
+	defer whsvr.policyMu.Unlock()
+	whsvr.policyEngine = engine
+}
+
+func (whsvr *WebhookServer) currentPolicyEngine() *policy.Engine {
+	whsvr.policyMu.RLock()
+	defer whsvr.policyMu.RUnlock()
+	return whsvr.policyEngine
+}
+
+// setCertificate installs the certificate the server should present to new
+// TLS connections, replacing whatever certificate was previously in use.
+// It is safe to call while the server is running, e.g. when a SelfSigner
+// rotates the leaf certificate.
+func (whsvr *WebhookServer) setCertificate(cert tls.Certificate) {
+	whsvr.certMu.Lock()
+	defer whsvr.certMu.Unlock()
+	whsvr.certPtr = &cert
+}
+
+// getCertificate implements tls.Config.GetCertificate so the server always
+// hands out the most recently installed certificate.
+func (whsvr *WebhookServer) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	whsvr.certMu.RLock()
+	defer whsvr.certMu.RUnlock()
+	if whsvr.certPtr == nil {
+		return nil, fmt.Errorf("no certificate has been configured")
+	}
+	return whsvr.certPtr, nil
 }
 
 // Webhook Server parameters
@@ -46,6 +131,8 @@ type WhSvrParameters struct {
 type Config struct {
 	Containers []corev1.Container `yaml:"containers"`
 	Volumes    []corev1.Volume    `yaml:"volumes"`
+	// IngressPolicy drives the Ingress TLS-host mutation policy.Engine.
+	IngressPolicy policy.Config `yaml:"ingressPolicy"`
 }
 
 type patchOperation struct {
@@ -57,6 +144,11 @@ type patchOperation struct {
 func init() {
 	_ = corev1.AddToScheme(runtimeScheme)
 	_ = admissionregistrationv1beta1.AddToScheme(runtimeScheme)
+	_ = admissionv1.AddToScheme(runtimeScheme)
+	_ = admissionv1beta1.AddToScheme(runtimeScheme)
+	_ = extensionsv1beta1.AddToScheme(runtimeScheme)
+	_ = networkingv1beta1.AddToScheme(runtimeScheme)
+	_ = networkingv1.AddToScheme(runtimeScheme)
 	// defaulting with webhooks:
 	// https://github.com/kubernetes/kubernetes/issues/57982
 	_ = v1.AddToScheme(runtimeScheme)
@@ -162,96 +254,271 @@ func createPatch(pod *corev1.Pod, sidecarConfig *Config, annotations map[string]
 	return json.Marshal(patch)
 }
 
-// main mutation process
-func (whsvr *WebhookServer) mutate(ar *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
-	req := ar.Request
-	if req.Kind.Version == "v1beta1" && req.Kind.Kind == "Ingress" {
-		var ingress extensionsv1beta1.Ingress
-		if err := json.Unmarshal(req.Object.Raw, &ingress); err != nil {
-			// Error unmarshalling Ingress
-			return &v1beta1.AdmissionResponse{
-				Result: &metav1.Status{
-					Message: err.Error(),
-				},
-			}
-		}
+// admissionRequest is a version-agnostic view of the fields of an
+// AdmissionRequest that the mutators below need, so the same code can
+// serve both admission.k8s.io/v1 and admission.k8s.io/v1beta1 callers.
+type admissionRequest struct {
+	uid       types.UID
+	kind      schema.GroupVersionKind
+	namespace string
+	object    []byte
+}
 
-		glog.Infof("Parsed Ingress: %s", ingress.String())
-		glog.Infof("Length of TLS: %v", len(ingress.Spec.TLS))
-
-		var patch []patchOperation
-
-		for tlsIndex, tls := range ingress.Spec.TLS {
-			glog.Infof("Length of %s: %v", tls.SecretName, len(tls.Hosts))
-			if len(tls.Hosts) == 0 {
-				// Need to generate a patch to add a single FQDN Host
-				// derived from the Ingress name and BKPR's DNS domain
-				glog.Infof("No Hosts for %s", tls.SecretName)
-				patch = append(patch, patchOperation{
-					Op:    "replace",
-					Path:  fmt.Sprintf("/spec/tls/%d/hosts", tlsIndex),
-					Value: []string{"cafe.eks.felipe-alfaro.com"},
-				})
-			} else {
-				for hostIndex, host := range tls.Hosts {
-					if len(host) == 0 {
-						// Empty Host: need to generate to replace its value with
-						// one derived from the Ingress name and BKPR's domain
-						glog.Info("Parsed No Host")
-						patch = append(patch, patchOperation{
-							Op:    "replace",
-							Path:  fmt.Sprintf("/spec/tls/%d/hosts/%d", tlsIndex, hostIndex),
-							Value: "cafe.eks.felipe-alfaro.com",
-						})
-					} else {
-						// Check whether Host is a FQDN
-						v := govalidator.New()
-						if err := v.Var(host, "fqdn"); err == nil {
-							glog.Infof("Parsed FQDN Host: %s", host)
-						} else {
-							// Non-FQDN: need to qualify the Host with BKPR's
-							// domain
-							newHost := host
-							if !strings.HasSuffix(host, ".") {
-								newHost += "."
-							}
-							newHost += "eks.felipe-alfaro.com"
-							patch = append(patch, patchOperation{
-								Op:    "replace",
-								Path:  fmt.Sprintf("/spec/tls/%d/hosts/%d", tlsIndex, hostIndex),
-								Value: newHost,
-							})
-							glog.Infof("Parsed non-FQDN: %s into: %s", host, newHost)
-						}
-					}
-				}
-			}
-		}
+// decodedIngress is a version-agnostic view of the fields of an Ingress
+// the policy Engine needs, common to extensions/v1beta1,
+// networking.k8s.io/v1beta1 and networking.k8s.io/v1 Ingress objects (their
+// TLS struct hasn't changed shape across those versions).
+type decodedIngress struct {
+	meta policy.Ingress
+	tls  []policy.TLSEntry
+}
 
-		patchBytes, err := json.Marshal(patch)
-		if err != nil {
-			glog.Error("Error marshalling patch!")
-			return &v1beta1.AdmissionResponse{
-				Result: &metav1.Status{
-					Message: err.Error(),
-				},
-			}
-		}
+// ingressDecoders maps the GVKs of every Ingress resource we know how to
+// mutate to a function that decodes it into the version-agnostic
+// representation above.
+var ingressDecoders = map[schema.GroupVersionKind]func([]byte) (decodedIngress, error){
+	{Group: "extensions", Version: "v1beta1", Kind: "Ingress"}:        decodeExtensionsV1beta1Ingress,
+	{Group: "networking.k8s.io", Version: "v1beta1", Kind: "Ingress"}: decodeNetworkingV1beta1Ingress,
+	{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}:      decodeNetworkingV1Ingress,
+}
+
+func decodeExtensionsV1beta1Ingress(raw []byte) (decodedIngress, error) {
+	var ingress extensionsv1beta1.Ingress
+	if err := json.Unmarshal(raw, &ingress); err != nil {
+		return decodedIngress{}, err
+	}
+	glog.Infof("Parsed Ingress: %s", ingress.String())
+	tls := make([]policy.TLSEntry, len(ingress.Spec.TLS))
+	for i, t := range ingress.Spec.TLS {
+		tls[i] = policy.TLSEntry{SecretName: t.SecretName, Hosts: t.Hosts}
+	}
+	return decodedIngress{
+		meta: policy.Ingress{Name: ingress.Name, Namespace: ingress.Namespace},
+		tls:  tls,
+	}, nil
+}
+
+func decodeNetworkingV1beta1Ingress(raw []byte) (decodedIngress, error) {
+	var ingress networkingv1beta1.Ingress
+	if err := json.Unmarshal(raw, &ingress); err != nil {
+		return decodedIngress{}, err
+	}
+	glog.Infof("Parsed Ingress: %s", ingress.String())
+	tls := make([]policy.TLSEntry, len(ingress.Spec.TLS))
+	for i, t := range ingress.Spec.TLS {
+		tls[i] = policy.TLSEntry{SecretName: t.SecretName, Hosts: t.Hosts}
+	}
+	return decodedIngress{
+		meta: policy.Ingress{Name: ingress.Name, Namespace: ingress.Namespace},
+		tls:  tls,
+	}, nil
+}
+
+func decodeNetworkingV1Ingress(raw []byte) (decodedIngress, error) {
+	var ingress networkingv1.Ingress
+	if err := json.Unmarshal(raw, &ingress); err != nil {
+		return decodedIngress{}, err
+	}
+	glog.Infof("Parsed Ingress: %s", ingress.String())
+	tls := make([]policy.TLSEntry, len(ingress.Spec.TLS))
+	for i, t := range ingress.Spec.TLS {
+		tls[i] = policy.TLSEntry{SecretName: t.SecretName, Hosts: t.Hosts}
+	}
+	return decodedIngress{
+		meta: policy.Ingress{Name: ingress.Name, Namespace: ingress.Namespace},
+		tls:  tls,
+	}, nil
+}
+
+// policyPatchOperations converts the policy-engine-neutral patch ops into
+// the patchOperation type the rest of the webhook marshals to JSON-Patch.
+func policyPatchOperations(ops []policy.PatchOp) []patchOperation {
+	patch := make([]patchOperation, len(ops))
+	for i, op := range ops {
+		patch[i] = patchOperation{Op: op.Op, Path: op.Path, Value: op.Value}
+	}
+	return patch
+}
+
+var podGVK = schema.GroupVersionKind{Version: "v1", Kind: "Pod"}
+
+// main mutation process: dispatches to the sidecar injector for Pods and to
+// the Ingress TLS-host policy for Ingresses, and allows everything else.
+func (whsvr *WebhookServer) mutate(req *admissionRequest) *admissionResponse {
+	if req.kind == podGVK {
+		return whsvr.mutatePod(req)
+	}
+	if decode, ok := ingressDecoders[req.kind]; ok {
+		return whsvr.mutateIngress(decode, req)
+	}
+	return &admissionResponse{allowed: true}
+}
+
+func (whsvr *WebhookServer) mutateIngress(decode func([]byte) (decodedIngress, error), req *admissionRequest) *admissionResponse {
+	ingress, err := decode(req.object)
+	if err != nil {
+		return &admissionResponse{result: &metav1.Status{Message: err.Error()}}
+	}
+
+	glog.Infof("Length of TLS: %v", len(ingress.tls))
+
+	ops, err := whsvr.currentPolicyEngine().Evaluate(ingress.meta, ingress.tls)
+	if err != nil {
+		glog.Errorf("Error evaluating ingress policy: %v", err)
+		return &admissionResponse{result: &metav1.Status{Message: err.Error()}}
+	}
+
+	patchBytes, err := json.Marshal(policyPatchOperations(ops))
+	if err != nil {
+		glog.Error("Error marshalling patch!")
+		return &admissionResponse{result: &metav1.Status{Message: err.Error()}}
+	}
+
+	glog.Infof("Patch: %v", string(patchBytes))
+	return &admissionResponse{allowed: true, patch: patchBytes}
+}
+
+// main validation process: checks Ingresses against the declared policy
+// invariants and allows everything else, including Pods.
+func (whsvr *WebhookServer) validate(req *admissionRequest) *admissionResponse {
+	if decode, ok := ingressDecoders[req.kind]; ok {
+		return whsvr.validateIngress(decode, req)
+	}
+	return &admissionResponse{allowed: true}
+}
+
+func (whsvr *WebhookServer) validateIngress(decode func([]byte) (decodedIngress, error), req *admissionRequest) *admissionResponse {
+	ingress, err := decode(req.object)
+	if err != nil {
+		return &admissionResponse{result: &metav1.Status{Message: err.Error()}}
+	}
 
-		glog.Infof("Patch: %v", string(patchBytes))
-		return &v1beta1.AdmissionResponse{
-			Allowed: true,
-			Patch:   patchBytes,
+	violations := whsvr.currentPolicyEngine().Validate(ingress.meta, ingress.tls)
+	if len(violations) == 0 {
+		return &admissionResponse{allowed: true}
+	}
+
+	messages := make([]string, len(violations))
+	for i, v := range violations {
+		messages[i] = fmt.Sprintf("%s: %s", v.Path, v.Message)
+	}
+	glog.Infof("Rejecting ingress %s/%s: %s", ingress.meta.Namespace, ingress.meta.Name, strings.Join(messages, "; "))
+
+	return &admissionResponse{
+		allowed: false,
+		result: &metav1.Status{
+			Reason:  metav1.StatusReasonInvalid,
+			Message: strings.Join(messages, "; "),
+		},
+	}
+}
+
+const (
+	admissionWebhookAnnotationInjectKey = "sidecar-injector-webhook/inject"
+	admissionWebhookAnnotationStatusKey = "sidecar-injector-webhook/status"
+	statusInjected                      = "injected"
+
+	// namespaceSelectorLabel is the label operators add to a namespace, with
+	// the value "enabled", to opt it into this webhook. When
+	// bootstrapSelfSignedCerts has the registrar package create the
+	// MutatingWebhookConfiguration, it puts this label into the
+	// configuration's namespaceSelector, so only labeled namespaces are sent
+	// Pods and Ingresses at all. An already existing configuration only has
+	// its caBundle patched; its namespaceSelector is left as it is.
+	namespaceSelectorLabel = "sidecar-injector-webhook"
+)
+
+// ignoredNamespaces are system namespaces the sidecar injector never
+// mutates Pods in, regardless of the opt-in annotation below. Operators
+// additionally scope which namespaces are even sent to this webhook via
+// the MutatingWebhookConfiguration's namespaceSelector (see
+// namespaceSelectorLabel).
+var ignoredNamespaces = []string{metav1.NamespaceSystem, metav1.NamespacePublic}
+
+// mutationRequired decides whether a Pod should receive the sidecar patch:
+// it must not be in an ignored namespace, must not already carry the
+// "injected" status annotation, and must opt in via the inject annotation.
+func mutationRequired(ignoredList []string, metadata *metav1.ObjectMeta) bool {
+	for _, namespace := range ignoredList {
+		if metadata.Namespace == namespace {
+			glog.Infof("Skip mutation for %v in special namespace %v", metadata.Name, metadata.Namespace)
+			return false
 		}
 	}
 
-	return &v1beta1.AdmissionResponse{
-		Allowed: true,
+	annotations := metadata.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+
+	if strings.EqualFold(annotations[admissionWebhookAnnotationStatusKey], statusInjected) {
+		glog.Infof("Skip mutation for %v/%v: already injected", metadata.Namespace, metadata.Name)
+		return false
+	}
+
+	switch strings.ToLower(annotations[admissionWebhookAnnotationInjectKey]) {
+	case "y", "yes", "true", "on":
+		return true
+	default:
+		return false
+	}
+}
+
+func (whsvr *WebhookServer) mutatePod(req *admissionRequest) *admissionResponse {
+	var pod corev1.Pod
+	if err := json.Unmarshal(req.object, &pod); err != nil {
+		return &admissionResponse{result: &metav1.Status{Message: err.Error()}}
+	}
+
+	if !mutationRequired(ignoredNamespaces, &pod.ObjectMeta) {
+		glog.Infof("Skipping mutation for %s/%s", pod.Namespace, pod.Name)
+		return &admissionResponse{allowed: true}
 	}
+
+	sidecarConfig := whsvr.currentSidecarConfig()
+	applyDefaultsWorkaround(sidecarConfig.Containers, sidecarConfig.Volumes)
+
+	annotations := map[string]string{admissionWebhookAnnotationStatusKey: statusInjected}
+	patchBytes, err := createPatch(&pod, sidecarConfig, annotations)
+	if err != nil {
+		return &admissionResponse{result: &metav1.Status{Message: err.Error()}}
+	}
+
+	glog.Infof("Patch: %v", string(patchBytes))
+	return &admissionResponse{allowed: true, patch: patchBytes}
+}
+
+// admissionResponse is the version-agnostic counterpart of admissionRequest;
+// serve translates it back into whichever AdmissionReview version the
+// apiserver sent.
+type admissionResponse struct {
+	allowed bool
+	patch   []byte
+	result  *metav1.Status
 }
 
-// Serve method for webhook server
-func (whsvr *WebhookServer) serve(w http.ResponseWriter, r *http.Request) {
+// serveMutate handles /mutate requests, running the sidecar injector and
+// Ingress TLS-host policy and replying with the resulting patch.
+func (whsvr *WebhookServer) serveMutate(w http.ResponseWriter, r *http.Request) {
+	whsvr.serve(w, r, "mutate", whsvr.mutate)
+}
+
+// serveValidate handles /validate requests, rejecting Ingresses that
+// violate the declared policy invariants.
+func (whsvr *WebhookServer) serveValidate(w http.ResponseWriter, r *http.Request) {
+	whsvr.serve(w, r, "validate", whsvr.validate)
+}
+
+// serve decodes the AdmissionReview in r, runs decide to reach an admission
+// decision, counts the outcome under endpoint in admissionRequestsTotal, and
+// replies in whichever AdmissionReview apiVersion the apiserver sent.
+func (whsvr *WebhookServer) serve(w http.ResponseWriter, r *http.Request, endpoint string, decide func(*admissionRequest) *admissionResponse) {
+	counted := func(req *admissionRequest) *admissionResponse {
+		resp := decide(req)
+		admissionRequestsTotal.WithLabelValues(endpoint, strconv.FormatBool(resp.allowed)).Inc()
+		return resp
+	}
+
 	var body []byte
 	if r.Body != nil {
 		if data, err := ioutil.ReadAll(r.Body); err == nil {
@@ -277,31 +544,29 @@ func (whsvr *WebhookServer) serve(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var admissionResponse *v1beta1.AdmissionResponse
-	ar := v1beta1.AdmissionReview{}
-	if _, _, err := deserializer.Decode(body, nil, &ar); err != nil {
+	// Content-negotiate on the AdmissionReview apiVersion the apiserver
+	// sent, so we can decode into the matching Go type and reply in kind.
+	var typeMeta metav1.TypeMeta
+	if err := json.Unmarshal(body, &typeMeta); err != nil {
 		glog.Errorf("Can't decode body: %v", err)
-		admissionResponse = &v1beta1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: err.Error(),
-			},
-		}
-	} else {
-		admissionResponse = whsvr.mutate(&ar)
+		http.Error(w, fmt.Sprintf("could not decode body: %v", err), http.StatusBadRequest)
+		return
 	}
 
-	admissionReview := v1beta1.AdmissionReview{}
-	if admissionResponse != nil {
-		admissionReview.Response = admissionResponse
-		if ar.Request != nil {
-			admissionReview.Response.UID = ar.Request.UID
-		}
+	var resp []byte
+	var err error
+	switch typeMeta.APIVersion {
+	case admissionv1.SchemeGroupVersion.String():
+		resp, err = whsvr.serveV1(body, counted)
+	case admissionv1beta1.SchemeGroupVersion.String(), "":
+		resp, err = whsvr.serveV1beta1(body, counted)
+	default:
+		err = fmt.Errorf("unsupported AdmissionReview apiVersion %q", typeMeta.APIVersion)
 	}
-
-	resp, err := json.Marshal(admissionReview)
 	if err != nil {
 		glog.Errorf("Can't encode response: %v", err)
 		http.Error(w, fmt.Sprintf("could not encode response: %v", err), http.StatusInternalServerError)
+		return
 	}
 
 	if _, err := w.Write(resp); err != nil {
@@ -309,3 +574,87 @@ func (whsvr *WebhookServer) serve(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("could not write response: %v", err), http.StatusInternalServerError)
 	}
 }
+
+func (whsvr *WebhookServer) serveV1(body []byte, decide func(*admissionRequest) *admissionResponse) ([]byte, error) {
+	ar := admissionv1.AdmissionReview{}
+	if _, _, err := deserializer.Decode(body, nil, &ar); err != nil {
+		glog.Errorf("Can't decode body: %v", err)
+		return json.Marshal(admissionv1.AdmissionReview{
+			TypeMeta: ar.TypeMeta,
+			Response: &admissionv1.AdmissionResponse{Result: &metav1.Status{Message: err.Error()}},
+		})
+	}
+	if ar.Request == nil {
+		err := fmt.Errorf("AdmissionReview has no request")
+		glog.Error(err)
+		return json.Marshal(admissionv1.AdmissionReview{
+			TypeMeta: ar.TypeMeta,
+			Response: &admissionv1.AdmissionResponse{Result: &metav1.Status{Message: err.Error()}},
+		})
+	}
+
+	resp := decide(&admissionRequest{
+		uid:       ar.Request.UID,
+		kind:      schema.GroupVersionKind(ar.Request.Kind),
+		namespace: ar.Request.Namespace,
+		object:    ar.Request.Object.Raw,
+	})
+
+	response := &admissionv1.AdmissionResponse{
+		UID:     ar.Request.UID,
+		Allowed: resp.allowed,
+		Result:  resp.result,
+	}
+	if len(resp.patch) > 0 {
+		patchType := admissionv1.PatchTypeJSONPatch
+		response.Patch = resp.patch
+		response.PatchType = &patchType
+	}
+
+	return json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: ar.TypeMeta,
+		Response: response,
+	})
+}
+
+func (whsvr *WebhookServer) serveV1beta1(body []byte, decide func(*admissionRequest) *admissionResponse) ([]byte, error) {
+	ar := admissionv1beta1.AdmissionReview{}
+	if _, _, err := deserializer.Decode(body, nil, &ar); err != nil {
+		glog.Errorf("Can't decode body: %v", err)
+		return json.Marshal(admissionv1beta1.AdmissionReview{
+			TypeMeta: ar.TypeMeta,
+			Response: &admissionv1beta1.AdmissionResponse{Result: &metav1.Status{Message: err.Error()}},
+		})
+	}
+	if ar.Request == nil {
+		err := fmt.Errorf("AdmissionReview has no request")
+		glog.Error(err)
+		return json.Marshal(admissionv1beta1.AdmissionReview{
+			TypeMeta: ar.TypeMeta,
+			Response: &admissionv1beta1.AdmissionResponse{Result: &metav1.Status{Message: err.Error()}},
+		})
+	}
+
+	resp := decide(&admissionRequest{
+		uid:       ar.Request.UID,
+		kind:      schema.GroupVersionKind(ar.Request.Kind),
+		namespace: ar.Request.Namespace,
+		object:    ar.Request.Object.Raw,
+	})
+
+	response := &admissionv1beta1.AdmissionResponse{
+		UID:     ar.Request.UID,
+		Allowed: resp.allowed,
+		Result:  resp.result,
+	}
+	if len(resp.patch) > 0 {
+		patchType := admissionv1beta1.PatchTypeJSONPatch
+		response.Patch = resp.patch
+		response.PatchType = &patchType
+	}
+
+	return json.Marshal(admissionv1beta1.AdmissionReview{
+		TypeMeta: ar.TypeMeta,
+		Response: response,
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCreatePatch(t *testing.T) {
+	pod := &corev1.Pod{}
+	sidecarConfig := &Config{
+		Containers: []corev1.Container{
+			{Name: "sidecar", Image: "sidecar:latest"},
+		},
+		Volumes: []corev1.Volume{
+			{Name: "sidecar-config"},
+		},
+	}
+
+	patchBytes, err := createPatch(pod, sidecarConfig, map[string]string{
+		admissionWebhookAnnotationStatusKey: statusInjected,
+	})
+	if err != nil {
+		t.Fatalf("createPatch returned an error: %v", err)
+	}
+
+	var patch []patchOperation
+	if err := json.Unmarshal(patchBytes, &patch); err != nil {
+		t.Fatalf("could not unmarshal patch: %v", err)
+	}
+	if len(patch) != 3 {
+		t.Fatalf("expected 3 patch operations (containers, volumes, annotations), got %d: %v", len(patch), patch)
+	}
+
+	// The pod starts with no containers/volumes, so addContainer/addVolume
+	// must emit the "empty target" first-element case: a single "add" whose
+	// value is the whole slice, at the base path rather than "<path>/-".
+	if patch[0].Op != "add" || patch[0].Path != "/spec/containers" {
+		t.Errorf("unexpected containers patch op: %+v", patch[0])
+	}
+	if patch[1].Op != "add" || patch[1].Path != "/spec/volumes" {
+		t.Errorf("unexpected volumes patch op: %+v", patch[1])
+	}
+	if patch[2].Op != "add" || patch[2].Path != "/metadata/annotations" {
+		t.Errorf("unexpected annotations patch op: %+v", patch[2])
+	}
+}
+
+func TestAddContainerEmptyTarget(t *testing.T) {
+	added := []corev1.Container{{Name: "sidecar", Image: "sidecar:latest"}}
+
+	patch := addContainer(nil, added, "/spec/containers")
+	if len(patch) != 1 {
+		t.Fatalf("expected 1 patch operation, got %d", len(patch))
+	}
+	if patch[0].Path != "/spec/containers" {
+		t.Errorf("expected the first add to target the base path, got %q", patch[0].Path)
+	}
+	value, ok := patch[0].Value.([]corev1.Container)
+	if !ok || len(value) != 1 {
+		t.Errorf("expected the first add's value to be the whole slice, got %#v", patch[0].Value)
+	}
+}
+
+func TestAddContainerNonEmptyTarget(t *testing.T) {
+	target := []corev1.Container{{Name: "app"}}
+	added := []corev1.Container{{Name: "sidecar", Image: "sidecar:latest"}}
+
+	patch := addContainer(target, added, "/spec/containers")
+	if len(patch) != 1 {
+		t.Fatalf("expected 1 patch operation, got %d", len(patch))
+	}
+	if patch[0].Path != "/spec/containers/-" {
+		t.Errorf("expected the add to append, got path %q", patch[0].Path)
+	}
+	if _, ok := patch[0].Value.(corev1.Container); !ok {
+		t.Errorf("expected the appended add's value to be a single container, got %#v", patch[0].Value)
+	}
+}
+
+func TestUpdateAnnotation(t *testing.T) {
+	patch := updateAnnotation(nil, map[string]string{admissionWebhookAnnotationStatusKey: statusInjected})
+	if len(patch) != 1 || patch[0].Op != "add" || patch[0].Path != "/metadata/annotations" {
+		t.Fatalf("expected a single add of /metadata/annotations, got %+v", patch)
+	}
+
+	existing := map[string]string{admissionWebhookAnnotationStatusKey: "not-injected"}
+	patch = updateAnnotation(existing, map[string]string{admissionWebhookAnnotationStatusKey: statusInjected})
+	wantPath := "/metadata/annotations/" + admissionWebhookAnnotationStatusKey
+	if len(patch) != 1 || patch[0].Op != "replace" || patch[0].Path != wantPath {
+		t.Fatalf("expected a single replace of the existing annotation, got %+v", patch)
+	}
+}
+
+func TestMutationRequired(t *testing.T) {
+	cases := []struct {
+		name     string
+		metadata metav1.ObjectMeta
+		want     bool
+	}{
+		{
+			name: "system namespace is always skipped",
+			metadata: metav1.ObjectMeta{
+				Namespace:   "kube-system",
+				Annotations: map[string]string{admissionWebhookAnnotationInjectKey: "true"},
+			},
+			want: false,
+		},
+		{
+			name: "already injected is skipped",
+			metadata: metav1.ObjectMeta{
+				Namespace: "default",
+				Annotations: map[string]string{
+					admissionWebhookAnnotationStatusKey: statusInjected,
+					admissionWebhookAnnotationInjectKey: "true",
+				},
+			},
+			want: false,
+		},
+		{
+			name:     "no opt-in annotation is skipped",
+			metadata: metav1.ObjectMeta{Namespace: "default"},
+			want:     false,
+		},
+		{
+			name: "opted in pod in a regular namespace is mutated",
+			metadata: metav1.ObjectMeta{
+				Namespace:   "default",
+				Annotations: map[string]string{admissionWebhookAnnotationInjectKey: "true"},
+			},
+			want: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := mutationRequired(ignoredNamespaces, &c.metadata); got != c.want {
+				t.Errorf("mutationRequired() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}